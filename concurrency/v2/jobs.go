@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wolfsoldier47/gdg-9/concurrency/v2/pkg/dbretry"
+)
+
+// JobStatus is the lifecycle state of a row in the jobs table.
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobDone      JobStatus = "done"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+// Job mirrors a row of the jobs table.
+type Job struct {
+	id          int
+	ip          string
+	size        int
+	status      JobStatus
+	submittedAt time.Time
+	startedAt   sql.NullTime
+	finishedAt  sql.NullTime
+	resultRef   sql.NullString
+	errMsg      sql.NullString
+}
+
+// jobWork is what actually travels through the worker channel.
+type jobWork struct {
+	id   int
+	ip   string
+	size int
+}
+
+// resultsDir holds the computed matrices, one file per job, so results are
+// streamed off disk instead of kept in memory for the life of the process.
+const resultsDir = "job-results"
+
+// JobQueue is a bounded worker pool that drains queued jobs from Postgres.
+type JobQueue struct {
+	work    chan jobWork
+	workers int
+
+	mu      sync.Mutex
+	cancels map[int]context.CancelFunc
+}
+
+func newJobQueue(workers, buffer int) *JobQueue {
+	return &JobQueue{
+		work:    make(chan jobWork, buffer),
+		workers: workers,
+		cancels: make(map[int]context.CancelFunc),
+	}
+}
+
+// start launches the configured number of worker goroutines.
+func (q *JobQueue) start() {
+	for i := 0; i < q.workers; i++ {
+		go q.runWorker()
+	}
+}
+
+func (q *JobQueue) runWorker() {
+	for w := range q.work {
+		q.process(w)
+	}
+}
+
+func (q *JobQueue) process(w jobWork) {
+	ctx, cancel := context.WithCancel(context.Background())
+	q.mu.Lock()
+	q.cancels[w.id] = cancel
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		delete(q.cancels, w.id)
+		q.mu.Unlock()
+		cancel()
+	}()
+
+	started, err := q.markStarted(w.id)
+	if err != nil {
+		fmt.Println("jobs: failed to mark job started:", err)
+		return
+	}
+	if !started {
+		// The row was cancelled (or already handled) before a worker picked
+		// it up; the channel delivery raced the cancellation, so skip the
+		// work rather than flipping a cancelled job back to running.
+		return
+	}
+
+	a := generateMatrix(w.size)
+	b := generateMatrix(w.size)
+	result := multiplyMatricesParallel(ctx, a, b, w.size)
+
+	select {
+	case <-ctx.Done():
+		if err := q.markCancelled(w.id); err != nil {
+			fmt.Println("jobs: failed to mark job cancelled:", err)
+		}
+		return
+	default:
+	}
+
+	ref, err := q.writeResult(w.id, result)
+	if err != nil {
+		if ferr := q.markFailed(w.id, err); ferr != nil {
+			fmt.Println("jobs: failed to mark job failed:", ferr)
+		}
+		return
+	}
+	if err := q.markDone(w.id, ref); err != nil {
+		fmt.Println("jobs: failed to mark job done:", err)
+	}
+}
+
+// writeResult persists the computed matrix to disk and returns the path to
+// be stored as result_ref.
+func (q *JobQueue) writeResult(id int, result [][]int) (string, error) {
+	if err := os.MkdirAll(resultsDir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(resultsDir, fmt.Sprintf("%d.json", id))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(result); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// markStarted transitions id from queued to running, but only if it's still
+// queued. It reports started=false if the row had already moved on (e.g.
+// cancelled while sitting in the buffered channel), so the caller can skip
+// doing the work instead of silently reviving a cancelled job.
+func (q *JobQueue) markStarted(id int) (started bool, err error) {
+	err = dbretry.Do(context.Background(), dbStats, dbretry.Options{}, func() error {
+		res, execErr := db.Exec(`UPDATE jobs SET status = $1, started_at = now() WHERE id = $2 AND status = $3`, JobRunning, id, JobQueued)
+		if execErr != nil {
+			return execErr
+		}
+		rows, rowsErr := res.RowsAffected()
+		if rowsErr != nil {
+			return rowsErr
+		}
+		started = rows > 0
+		return nil
+	})
+	return started, err
+}
+
+func (q *JobQueue) markDone(id int, resultRef string) error {
+	return dbretry.Do(context.Background(), dbStats, dbretry.Options{}, func() error {
+		_, err := db.Exec(`UPDATE jobs SET status = $1, finished_at = now(), result_ref = $2 WHERE id = $3`, JobDone, resultRef, id)
+		return err
+	})
+}
+
+func (q *JobQueue) markFailed(id int, jobErr error) error {
+	return dbretry.Do(context.Background(), dbStats, dbretry.Options{}, func() error {
+		_, err := db.Exec(`UPDATE jobs SET status = $1, finished_at = now(), error = $2 WHERE id = $3`, JobFailed, jobErr.Error(), id)
+		return err
+	})
+}
+
+func (q *JobQueue) markCancelled(id int) error {
+	return dbretry.Do(context.Background(), dbStats, dbretry.Options{}, func() error {
+		_, err := db.Exec(`UPDATE jobs SET status = $1, finished_at = now() WHERE id = $2`, JobCancelled, id)
+		return err
+	})
+}
+
+// cancel transitions a still-queued-or-running job to cancelled, signalling
+// the worker's context if one has already picked it up.
+func (q *JobQueue) cancel(id int) {
+	q.mu.Lock()
+	cancel, running := q.cancels[id]
+	q.mu.Unlock()
+	if running {
+		cancel()
+		return
+	}
+	err := dbretry.Do(context.Background(), dbStats, dbretry.Options{}, func() error {
+		_, err := db.Exec(`UPDATE jobs SET status = $1, finished_at = now() WHERE id = $2 AND status = $3`, JobCancelled, id, JobQueued)
+		return err
+	})
+	if err != nil {
+		fmt.Println("jobs: failed to cancel queued job:", err)
+	}
+}
+
+// ErrQueueFull is returned by submitJob when every worker is busy and the
+// buffered channel is already full. Callers should reject the request
+// (e.g. with 503) rather than block, since store-and-forward means the
+// caller is waiting synchronously for a job id, not for the work itself.
+var ErrQueueFull = errors.New("jobs: queue is full")
+
+// submitJob inserts a queued job row and hands it to a worker. The handoff
+// to the worker channel is non-blocking: a full channel means ErrQueueFull
+// instead of hanging the calling goroutine (typically an HTTP handler)
+// until a worker frees up.
+func (q *JobQueue) submitJob(ctx context.Context, ip string, size int) (int, error) {
+	var id int
+	err := dbretry.Do(ctx, dbStats, dbretry.Options{}, func() error {
+		return db.QueryRowContext(ctx,
+			`INSERT INTO jobs (ip, size, status) VALUES ($1, $2, $3) RETURNING id`,
+			ip, size, JobQueued,
+		).Scan(&id)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	select {
+	case q.work <- jobWork{id: id, ip: ip, size: size}:
+		return id, nil
+	default:
+		// No worker will ever see this row; clean it up rather than
+		// leaving it stuck at "queued" until a process restart's
+		// requeuePending happens to pick it back up.
+		if derr := dbretry.Do(ctx, dbStats, dbretry.Options{}, func() error {
+			_, err := db.ExecContext(ctx, `DELETE FROM jobs WHERE id = $1`, id)
+			return err
+		}); derr != nil {
+			fmt.Println("jobs: failed to clean up job rejected for a full queue:", derr)
+		}
+		return 0, ErrQueueFull
+	}
+}
+
+// requeuePending re-enqueues jobs left queued or running by a previous
+// process that exited before finishing them, so no submission is lost.
+func (q *JobQueue) requeuePending() error {
+	var pending []jobWork
+	err := dbretry.Do(context.Background(), dbStats, dbretry.Options{}, func() error {
+		rows, err := db.Query(`SELECT id, ip, size FROM jobs WHERE status IN ($1, $2) ORDER BY id`, JobQueued, JobRunning)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		pending = nil
+		for rows.Next() {
+			var w jobWork
+			if err := rows.Scan(&w.id, &w.ip, &w.size); err != nil {
+				return err
+			}
+			pending = append(pending, w)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, w := range pending {
+		err := dbretry.Do(context.Background(), dbStats, dbretry.Options{}, func() error {
+			_, err := db.Exec(`UPDATE jobs SET status = $1 WHERE id = $2`, JobQueued, w.id)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		q.work <- w
+	}
+	return nil
+}
+
+var jobQueue *JobQueue
+
+// jobWorkerCount reads the worker pool size from JOB_WORKERS, defaulting to 4.
+func jobWorkerCount() int {
+	if n, err := strconv.Atoi(os.Getenv("JOB_WORKERS")); err == nil && n > 0 {
+		return n
+	}
+	return 4
+}
+
+// jobsRouter dispatches /jobs/{id} and /jobs/{id}/result; the repo doesn't
+// pull in a routing library, so this just does the path splitting by hand.
+func jobsRouter(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/result") {
+		jobResultHandler(w, r)
+		return
+	}
+	jobStatusHandler(w, r)
+}
+
+// jobStatusHandler serves GET /jobs/{id}.
+func jobStatusHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/jobs/"))
+	if err != nil {
+		http.Error(w, "invalid job id", http.StatusBadRequest)
+		return
+	}
+
+	var j Job
+	err = db.QueryRow(
+		`SELECT id, ip, size, status, submitted_at, started_at, finished_at, result_ref, error FROM jobs WHERE id = $1`, id,
+	).Scan(&j.id, &j.ip, &j.size, &j.status, &j.submittedAt, &j.startedAt, &j.finishedAt, &j.resultRef, &j.errMsg)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to load job", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":           j.id,
+		"ip":           j.ip,
+		"size":         j.size,
+		"status":       j.status,
+		"submitted_at": j.submittedAt,
+	})
+}
+
+// jobResultHandler serves GET /jobs/{id}/result, streaming the result
+// straight off disk instead of holding it in memory.
+func jobResultHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/result")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "invalid job id", http.StatusBadRequest)
+		return
+	}
+
+	var status JobStatus
+	var resultRef sql.NullString
+	err = db.QueryRow(`SELECT status, result_ref FROM jobs WHERE id = $1`, id).Scan(&status, &resultRef)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to load job", http.StatusInternalServerError)
+		return
+	}
+	if status != JobDone || !resultRef.Valid {
+		http.Error(w, fmt.Sprintf("job %d is %s, no result yet", id, status), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	http.ServeFile(w, r, resultRef.String)
+}
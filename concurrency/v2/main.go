@@ -3,32 +3,90 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
 	"net/http"
 	"os"
-	"runtime/trace"
+	"os/signal"
 	"strconv"
 	"sync"
+	"syscall"
 	"time"
 
 	_ "github.com/lib/pq" // Import the PostgreSQL driver
+
+	"github.com/wolfsoldier47/gdg-9/concurrency/v2/migrations"
+	"github.com/wolfsoldier47/gdg-9/concurrency/v2/pkg/dbretry"
+	"github.com/wolfsoldier47/gdg-9/concurrency/v2/rotate"
 )
 
 // Store active requests and their cancel functions
 var activeRequests = make(map[string]context.CancelFunc)
-var mu sync.Mutex // Mutex to protect the activeRequests map
+var activeJobs = make(map[string]int) // ip -> job id, for requests handed off to the job queue
+var mu sync.Mutex                     // Mutex to protect activeRequests and activeJobs
 
 // Database connection
 var db *sql.DB
 
+// tracer manages the runtime/trace output file, rotating it by size/age
+// instead of growing trace.out unboundedly for the life of the process.
+var tracer *rotate.Tracer
+
+// requestLog is a structured, rotating log of handled requests.
+var requestLog *rotate.RotatingFile
+
+const (
+	traceMaxSize   = 50 * 1024 * 1024 // 50MB
+	traceMaxAge    = 24 * time.Hour
+	traceBackups   = 10
+	traceRetainAge = 7 * 24 * time.Hour
+)
+
+// logRequest appends a structured JSON line describing a handled request to
+// requestLog.
+func logRequest(ip string, matrixSize int, status string) {
+	if requestLog == nil {
+		return
+	}
+	line, err := json.Marshal(map[string]interface{}{
+		"time":   time.Now().Format(time.RFC3339),
+		"ip":     ip,
+		"size":   matrixSize,
+		"status": status,
+	})
+	if err != nil {
+		return
+	}
+	requestLog.Write(append(line, '\n'))
+}
+
+// watchForRotateSignal rotates the trace and request logs on SIGHUP,
+// without dropping whatever is currently mid-write.
+func watchForRotateSignal() {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			if err := tracer.Rotate(); err != nil {
+				fmt.Println("Failed to rotate trace output:", err)
+			}
+			if requestLog != nil {
+				if err := requestLog.Rotate(); err != nil {
+					fmt.Println("Failed to rotate request log:", err)
+				}
+			}
+		}
+	}()
+}
+
 type Requests struct {
-	id          int
-	ip          string
-	matrix_size int
-	created_at  time.Time
-	// Add more fields based on your database schema
+	ID         int       `json:"id"`
+	IP         string    `json:"ip"`
+	MatrixSize int       `json:"matrix_size"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
 // generateMatrix creates a matrix of given size with random values.
@@ -76,13 +134,21 @@ func multiplyMatricesParallel(ctx context.Context, a, b [][]int, size int) [][]i
 	return result
 }
 
-// cancelPreviousRequest cancels all previous requests from the same IP.
+// cancelPreviousRequest cancels all previous requests from the same IP,
+// including a job still queued or running in the job queue.
 func cancelPreviousRequest(ip string) {
 	mu.Lock()
-	defer mu.Unlock()
-	if cancelFunc, exists := activeRequests[ip]; exists {
+	cancelFunc, hasRequest := activeRequests[ip]
+	delete(activeRequests, ip)
+	jobID, hasJob := activeJobs[ip]
+	delete(activeJobs, ip)
+	mu.Unlock()
+
+	if hasRequest {
 		cancelFunc() // Cancel the previous request
-		delete(activeRequests, ip)
+	}
+	if hasJob && jobQueue != nil {
+		jobQueue.cancel(jobID)
 	}
 }
 
@@ -94,24 +160,26 @@ func extractIP(remoteAddr string) string {
 	return remoteAddr // In case the address has no port
 }
 
-// storeRequestInfo stores the IP and matrix size in the PostgreSQL database.
-func storeRequestInfo(ip string, matrixSize int) error {
-	_, err := db.Exec("INSERT INTO requests (ip, matrix_size) VALUES ($1, $2)", ip, matrixSize)
-	return err
+// dbStats tracks retry/backoff behaviour for all writes against db, surfaced
+// on /debug/db-stats.
+var dbStats = &dbretry.Statistics{}
+
+// storeRequestInfo stores the IP and matrix size in the PostgreSQL database,
+// retrying transient errors with backoff. ctx lets cancelPreviousRequest
+// abort pending retries immediately.
+func storeRequestInfo(ctx context.Context, ip string, matrixSize int) error {
+	return dbretry.Do(ctx, dbStats, dbretry.Options{}, func() error {
+		_, err := db.ExecContext(ctx, "INSERT INTO requests (ip, matrix_size) VALUES ($1, $2)", ip, matrixSize)
+		return err
+	})
 }
 
-// migrate ensures the requests table exists in the database.
+// migrate brings the database up to the latest schema version, retrying
+// transient connection errors with backoff.
 func migrate() error {
-	// SQL command to create the requests table if it does not exist
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS requests (
-		id SERIAL PRIMARY KEY,
-		ip VARCHAR(255),
-		matrix_size INTEGER,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);`
-	_, err := db.Exec(createTableSQL)
-	return err
+	return dbretry.Do(context.Background(), dbStats, dbretry.Options{}, func() error {
+		return migrations.New(db).Up(context.Background())
+	})
 }
 
 // createDatabase creates the specified database if it doesn't exist.
@@ -167,14 +235,32 @@ func handler(w http.ResponseWriter, r *http.Request) {
 		matrixSize = 100 // Default size if invalid or not provided
 	}
 
-	if matrixSize > 2000 {
-		select {
-		case <-ctx.Done():
-			fmt.Fprintf(w, "Request was cancelled due to large number\n")
+	asyncRequested := r.URL.Query().Get("async") == "true"
+
+	if matrixSize > 2000 || asyncRequested {
+		// Oversized (or explicitly async) requests are handed off to the
+		// job queue instead of being computed inline.
+		jobID, err := jobQueue.submitJob(ctx, ip, matrixSize)
+		if errors.Is(err, ErrQueueFull) {
+			http.Error(w, "Job queue is full, try again later", http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to submit job", http.StatusInternalServerError)
+			return
 		}
+		mu.Lock()
+		activeJobs[ip] = jobID
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"job_id": jobID,
+			"status": JobQueued,
+		})
 	} else {
 		// Store the request info in the database
-		if err := storeRequestInfo(ip, matrixSize); err != nil {
+		if err := storeRequestInfo(ctx, ip, matrixSize); err != nil {
 			http.Error(w, "Failed to store request info", http.StatusInternalServerError)
 			return
 		}
@@ -192,6 +278,7 @@ func handler(w http.ResponseWriter, r *http.Request) {
 		select {
 		case <-ctx.Done():
 			fmt.Fprintf(w, "Request was cancelled\n")
+			logRequest(ip, matrixSize, "cancelled")
 		default:
 			// Send the matrix and the duration back to the client
 			fmt.Fprintf(w, "Matrix multiplication (size: %d) completed in %d milliseconds\n", matrixSize, duration)
@@ -199,76 +286,67 @@ func handler(w http.ResponseWriter, r *http.Request) {
 			for _, row := range result {
 				fmt.Fprintf(w, "%v\n", row)
 			}
+			logRequest(ip, matrixSize, "completed")
 		}
 	}
 }
 
-func getRequestInfo(ip string, page int, pageSize int) ([]Requests, error) {
-	// Define the offset for pagination
-	//offset := (page - 1) * pageSize
-
-	// Create the SQL query
-	query := `SELECT * FROM requests WHERE IP = $1 ORDER BY id `
-
-	// Prepare a slice to hold your results
-	var results []Requests
-
-	// Execute the query
-	rows, err := db.Query(query, ip)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	// Iterate through the rows
-	for rows.Next() {
-		var data Requests
-		if err := rows.Scan(&data.id, &data.ip, &data.matrix_size, &data.created_at); err != nil {
-			return nil, err
-		}
-		results = append(results, data)
-
-	}
-	// Check for any error encountered during iteration
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-
-	return results, nil
-}
-
 // Serve the HTML page
 func servePage(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "index.html")
 }
 
-func allValues(w http.ResponseWriter, r *http.Request) {
-	// Parse the page and pageSize from query parameters
-	page := r.URL.Query().Get("page")
-	pageSize := r.URL.Query().Get("pageSize")
-
-	// Convert string parameters to integers (you may want to handle potential errors)
-	currentPage := 1
-	currentPageSize := 10
-
-	if page != "" {
-		p, err := strconv.Atoi(page)
-		if err == nil {
-			currentPage = p
+// dbStatsHandler serves GET /debug/db-stats with the current retry/backoff
+// counters for database writes.
+func dbStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dbStats.Snapshot())
+}
+
+// requestQueryFromParams builds a RequestQuery from the query parameters
+// shared by /all-values and /api/requests.
+func requestQueryFromParams(query map[string][]string, defaultIP string) RequestQuery {
+	get := func(key string) string {
+		if v, ok := query[key]; ok && len(v) > 0 {
+			return v[0]
 		}
+		return ""
 	}
 
-	if pageSize != "" {
-		ps, err := strconv.Atoi(pageSize)
-		if err == nil {
-			currentPageSize = ps
+	q := RequestQuery{
+		IP:      defaultIP,
+		Cursor:  get("cursor"),
+		OrderBy: OrderColumn(get("order_by")),
+		Desc:    get("order") != "asc",
+	}
+	if ip := get("ip"); ip != "" {
+		q.IP = ip
+	}
+	if v := get("size_min"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			q.SizeMin = &n
+		}
+	}
+	if v := get("size_max"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			q.SizeMax = &n
 		}
 	}
+	if v := get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			q.Limit = n
+		}
+	}
+	return q
+}
 
-	ip := extractIP(r.RemoteAddr)
+// allValues serves GET /all-values, an HTML table of requests, filtered to
+// the caller's IP by default and paginated with ?cursor=... instead of
+// OFFSET so the query stays cheap as the table grows.
+func allValues(w http.ResponseWriter, r *http.Request) {
+	q := requestQueryFromParams(r.URL.Query(), extractIP(r.RemoteAddr))
 
-	// Get request info
-	requests, err := getRequestInfo(ip, currentPage, currentPageSize)
+	page, err := QueryRequests(r.Context(), q)
 	if err != nil {
 		http.Error(w, "Unable to fetch data", http.StatusInternalServerError)
 		return
@@ -286,7 +364,7 @@ func allValues(w http.ResponseWriter, r *http.Request) {
     </thead>
     <tbody>`
 
-	for _, req := range requests {
+	for _, req := range page.Items {
 		responseHtml += fmt.Sprintf(`
 				<tr>
 					<td>%d</td>
@@ -294,20 +372,125 @@ func allValues(w http.ResponseWriter, r *http.Request) {
 					<td>%d</td>
 					<td>%s</td>
 				</tr>`,
-			req.id, req.ip, req.matrix_size, req.created_at.Format("2006-01-02 15:04:05"))
+			req.ID, req.IP, req.MatrixSize, req.CreatedAt.Format("2006-01-02 15:04:05"))
 	}
 
 	responseHtml += `</tbody></table>`
 
-	// Optionally, add pagination controls if needed
-	// responseHtml += `<div>...pagination links...</div>`
+	if page.HasMore {
+		responseHtml += fmt.Sprintf(`<div><a href="?cursor=%s">Next page</a></div>`, page.NextCursor)
+	}
 
 	// Write the HTML response
 	w.Header().Set("Content-Type", "text/html")
 	w.Write([]byte(responseHtml))
 }
 
+// apiRequestsHandler serves GET /api/requests, the JSON equivalent of
+// /all-values for programmatic consumers.
+func apiRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	q := requestQueryFromParams(r.URL.Query(), "")
+
+	page, err := QueryRequests(r.Context(), q)
+	if err != nil {
+		http.Error(w, "Unable to fetch data", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// runMigrateCLI handles the `migrate up|down|version|create <name>`
+// subcommands so operators can change schema without redeploying the app.
+// It returns true if it handled (and the process should exit) os.Args.
+func runMigrateCLI() bool {
+	if len(os.Args) < 2 || os.Args[1] != "migrate" {
+		return false
+	}
+	if len(os.Args) < 3 {
+		fmt.Println("usage: migrate up|down|version|create <name>")
+		return true
+	}
+
+	if os.Args[2] == "create" {
+		if len(os.Args) < 4 {
+			fmt.Println("usage: migrate create <name>")
+			return true
+		}
+		up, down, err := migrations.Create("migrations/migrations", os.Args[3])
+		if err != nil {
+			fmt.Println("Failed to scaffold migration:", err)
+			return true
+		}
+		fmt.Println("Created", up, "and", down)
+		return true
+	}
+
+	if err := connectDB(); err != nil {
+		fmt.Println("Failed to connect to database:", err)
+		return true
+	}
+	defer db.Close()
+
+	m := migrations.New(db)
+	ctx := context.Background()
+	switch os.Args[2] {
+	case "up":
+		if err := m.Up(ctx); err != nil {
+			fmt.Println("Migration failed:", err)
+		}
+	case "down":
+		steps := 1
+		if len(os.Args) > 3 {
+			if n, err := strconv.Atoi(os.Args[3]); err == nil {
+				steps = n
+			}
+		}
+		if err := m.Down(ctx, steps); err != nil {
+			fmt.Println("Migration failed:", err)
+		}
+	case "version":
+		version, dirty, err := m.Version(ctx)
+		if err != nil {
+			fmt.Println("Failed to read version:", err)
+		} else {
+			fmt.Printf("version %d, dirty=%v\n", version, dirty)
+		}
+	default:
+		fmt.Println("usage: migrate up|down|version|create <name>")
+	}
+	return true
+}
+
+// connectDB opens db using the same environment variables main() uses,
+// creating the target database first if it doesn't exist.
+func connectDB() error {
+	username := os.Getenv("DB_USERNAME")
+	password := os.Getenv("DB_PASSWORD")
+	dbName := os.Getenv("DB_NAME")
+	host := os.Getenv("DB_HOST")
+
+	exists, err := checkDatabaseExists(dbName, username, host, password)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := createDatabase(dbName, username, password); err != nil {
+			return err
+		}
+	}
+
+	connStr := fmt.Sprintf("user=%s dbname=%s password=%s host=%s sslmode=disable", username, dbName, password, host)
+	db, err = sql.Open("postgres", connStr)
+	return err
+}
+
 func main() {
+	if runMigrateCLI() {
+		return
+	}
+
 	// Connect to PostgreSQL database using environment variables
 	var err error
 	username := os.Getenv("DB_USERNAME")
@@ -347,14 +530,58 @@ func main() {
 		return
 	}
 
-	f, _ := os.Create("trace.out")
-	trace.Start(f)
-	defer trace.Stop()
+	// Start the job queue's worker pool and requeue anything left
+	// queued/running by a previous process before it exited.
+	jobQueue = newJobQueue(jobWorkerCount(), 100)
+	jobQueue.start()
+	if err := jobQueue.requeuePending(); err != nil {
+		fmt.Println("Failed to requeue pending jobs:", err)
+	}
+
+	tracer, err = rotate.NewTracer(rotate.Options{
+		Path:         "trace.out",
+		MaxSize:      traceMaxSize,
+		MaxAge:       traceMaxAge,
+		MaxBackups:   traceBackups,
+		MaxAgeRetain: traceRetainAge,
+	})
+	if err != nil {
+		fmt.Println("Failed to start trace:", err)
+		return
+	}
+	defer tracer.Stop()
+
+	requestLog, err = rotate.New(rotate.Options{
+		Path:         "requests.log",
+		MaxSize:      traceMaxSize,
+		MaxAge:       traceMaxAge,
+		MaxBackups:   traceBackups,
+		MaxAgeRetain: traceRetainAge,
+	})
+	if err != nil {
+		fmt.Println("Failed to open request log:", err)
+		return
+	}
+	defer requestLog.Close()
+
+	watchForRotateSignal()
+
+	maintenanceScheduler, err := newMaintenanceScheduler(db)
+	if err != nil {
+		fmt.Println("Failed to configure maintenance scheduler:", err)
+		return
+	}
+	maintenanceScheduler.Start()
+	defer maintenanceScheduler.Stop()
 
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./static/"))))
 
 	http.HandleFunc("/cpu-intensive", handler)
 	http.HandleFunc("/all-values", allValues)
+	http.HandleFunc("/api/requests", apiRequestsHandler)
+	http.HandleFunc("/jobs/", jobsRouter)
+	http.HandleFunc("/debug/db-stats", dbStatsHandler)
+	http.HandleFunc("/stats/top", topStatsHandler)
 	http.HandleFunc("/", servePage)
 
 	port := 8080
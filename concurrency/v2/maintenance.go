@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/wolfsoldier47/gdg-9/concurrency/v2/scheduler"
+)
+
+const (
+	defaultCronRollup    = "0 5 * * * *"   // five minutes past every hour
+	defaultCronRetention = "0 0 3 * * *"   // daily at 03:00
+	defaultCronTopN      = "0 * * * * *"   // once a minute
+	defaultRetentionDays = 90
+	topNSize             = 10
+)
+
+// topIPsCache holds the most recently computed top-N IPs by request count,
+// recomputed once a minute so /stats/top never has to scan the requests
+// table inline.
+var topIPsCache struct {
+	mu    sync.RWMutex
+	items []TopIP
+}
+
+// TopIP is one row of the top-N-IPs cache.
+type TopIP struct {
+	IP    string `json:"ip"`
+	Count int    `json:"count"`
+}
+
+func setTopIPs(items []TopIP) {
+	topIPsCache.mu.Lock()
+	defer topIPsCache.mu.Unlock()
+	topIPsCache.items = items
+}
+
+func getTopIPs() []TopIP {
+	topIPsCache.mu.RLock()
+	defer topIPsCache.mu.RUnlock()
+	return topIPsCache.items
+}
+
+// rollupHourlyJob aggregates the requests table into requests_hourly with a
+// single INSERT ... SELECT ... ON CONFLICT DO UPDATE.
+func rollupHourlyJob(ctx context.Context, db *sql.DB) (int64, error) {
+	res, err := db.ExecContext(ctx, `
+	INSERT INTO requests_hourly (ip, hour, count, avg_size, p95_size)
+	SELECT
+		ip,
+		date_trunc('hour', created_at) AS hour,
+		count(*),
+		avg(matrix_size),
+		percentile_cont(0.95) WITHIN GROUP (ORDER BY matrix_size)
+	FROM requests
+	WHERE created_at >= now() - interval '2 hours'
+	GROUP BY ip, date_trunc('hour', created_at)
+	ON CONFLICT (ip, hour) DO UPDATE SET
+		count = EXCLUDED.count,
+		avg_size = EXCLUDED.avg_size,
+		p95_size = EXCLUDED.p95_size
+	`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// retentionDays returns how many days of raw requests rows to keep,
+// configured via RETENTION_DAYS.
+func retentionDays() int {
+	if n, err := strconv.Atoi(os.Getenv("RETENTION_DAYS")); err == nil && n > 0 {
+		return n
+	}
+	return defaultRetentionDays
+}
+
+// retentionJob deletes raw requests rows older than the configured
+// retention window.
+func retentionJob(ctx context.Context, db *sql.DB) (int64, error) {
+	res, err := db.ExecContext(ctx,
+		`DELETE FROM requests WHERE created_at < now() - ($1 || ' days')::interval`, retentionDays())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// topNJob recomputes the in-memory top-N-IPs cache surfaced at
+// GET /stats/top.
+func topNJob(ctx context.Context, db *sql.DB) (int64, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT ip, count(*) AS c FROM requests GROUP BY ip ORDER BY c DESC LIMIT $1`, topNSize)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var items []TopIP
+	for rows.Next() {
+		var t TopIP
+		if err := rows.Scan(&t.IP, &t.Count); err != nil {
+			return 0, err
+		}
+		items = append(items, t)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Count > items[j].Count })
+	setTopIPs(items)
+	return int64(len(items)), nil
+}
+
+// cronSpec reads a cron spec from the environment, falling back to def.
+func cronSpec(envVar, def string) string {
+	if v := os.Getenv(envVar); v != "" {
+		return v
+	}
+	return def
+}
+
+// newMaintenanceScheduler builds the scheduler with the rollup, retention,
+// and top-N jobs, all configurable via CRON_ROLLUP / CRON_RETENTION /
+// CRON_TOPN.
+func newMaintenanceScheduler(db *sql.DB) (*scheduler.Scheduler, error) {
+	s := scheduler.New(db)
+
+	jobs := []scheduler.Job{
+		{Name: "rollup_hourly", Spec: cronSpec("CRON_ROLLUP", defaultCronRollup), Fn: rollupHourlyJob},
+		{Name: "retention", Spec: cronSpec("CRON_RETENTION", defaultCronRetention), Fn: retentionJob},
+		{Name: "top_n_ips", Spec: cronSpec("CRON_TOPN", defaultCronTopN), Fn: topNJob},
+	}
+	for _, job := range jobs {
+		if err := s.Add(job); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// topStatsHandler serves GET /stats/top with the cached top-N IPs.
+func topStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(getTopIPs())
+}
@@ -0,0 +1,309 @@
+// Package migrations implements a small, dependency-free schema migration
+// runner modeled on the mattes/migrate approach: numbered pairs of
+// NNN_name.up.sql / NNN_name.down.sql files, tracked in a schema_migrations
+// table, applied one at a time under a Postgres advisory lock so multiple
+// replicas starting at once don't race each other.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var embeddedFiles embed.FS
+
+// advisoryLockKey is an arbitrary constant used with pg_advisory_lock so
+// concurrent replicas serialize on migrations instead of racing.
+const advisoryLockKey = 918273645
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_([a-zA-Z0-9_]+)\.(up|down)\.sql$`)
+
+// migration is one numbered step, with both directions loaded.
+type migration struct {
+	version uint64
+	name    string
+	up      string
+	down    string
+}
+
+// Migrator applies and tracks schema migrations against db.
+type Migrator struct {
+	db *sql.DB
+}
+
+// New returns a Migrator backed by db.
+func New(db *sql.DB) *Migrator {
+	return &Migrator{db: db}
+}
+
+func loadMigrations() ([]migration, error) {
+	entries, err := embeddedFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[uint64]*migration{}
+	for _, entry := range entries {
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrations: bad version in %s: %w", entry.Name(), err)
+		}
+		contents, err := embeddedFiles.ReadFile(filepath.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: match[2]}
+			byVersion[version] = m
+		}
+		if match[3] == "up" {
+			m.up = string(contents)
+		} else {
+			m.down = string(contents)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+// ensureBookkeeping creates the schema_migrations table if it doesn't exist.
+func (m *Migrator) ensureBookkeeping(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		dirty BOOLEAN NOT NULL DEFAULT false,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);`)
+	return err
+}
+
+// withLock runs fn while holding a Postgres advisory lock, releasing it
+// afterwards regardless of outcome.
+func (m *Migrator) withLock(ctx context.Context, fn func(*sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, advisoryLockKey); err != nil {
+		return fmt.Errorf("migrations: failed to acquire advisory lock: %w", err)
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, advisoryLockKey)
+
+	return fn(conn)
+}
+
+// Version returns the currently applied version and whether the last
+// migration left the database in a dirty (partially applied) state.
+func (m *Migrator) Version(ctx context.Context) (version uint64, dirty bool, err error) {
+	if err := m.ensureBookkeeping(ctx); err != nil {
+		return 0, false, err
+	}
+	row := m.db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations ORDER BY version DESC LIMIT 1`)
+	if err := row.Scan(&version, &dirty); err == sql.ErrNoRows {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return version, dirty, nil
+}
+
+// Force sets the recorded version without running any SQL, clearing the
+// dirty flag. Used to recover from a dirty state after a manual fix.
+func (m *Migrator) Force(ctx context.Context, version uint64) error {
+	if err := m.ensureBookkeeping(ctx); err != nil {
+		return err
+	}
+	if _, err := m.db.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version > $1`, version); err != nil {
+		return err
+	}
+	_, err := m.db.ExecContext(ctx, `
+	INSERT INTO schema_migrations (version, dirty, applied_at) VALUES ($1, false, now())
+	ON CONFLICT (version) DO UPDATE SET dirty = false`, version)
+	return err
+}
+
+// Up applies every migration newer than the current version, in order.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.Goto(ctx, ^uint64(0))
+}
+
+// Down rolls back the given number of applied migrations, most recent first.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	if steps <= 0 {
+		return nil
+	}
+	current, dirty, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("migrations: database is dirty at version %d, run Force first", current)
+	}
+
+	all, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	applied := applicableDown(all, current, steps)
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		for _, mg := range applied {
+			// Retire the bookkeeping row for mg.version (the migration being
+			// undone) so Version() reflects the rollback instead of still
+			// reporting the pre-rollback version forever.
+			if err := m.applyOne(ctx, conn, mg, mg.down, mg.version-1, mg.version); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Goto migrates up or down to land exactly on the given version.
+func (m *Migrator) Goto(ctx context.Context, version uint64) error {
+	current, dirty, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("migrations: database is dirty at version %d, run Force first", current)
+	}
+
+	all, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	if version < current {
+		steps := 0
+		for _, mg := range all {
+			if mg.version > version && mg.version <= current {
+				steps++
+			}
+		}
+		return m.Down(ctx, steps)
+	}
+
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		for _, mg := range all {
+			if mg.version <= current || mg.version > version {
+				continue
+			}
+			// resultVersion == mg.version here, so there's no separate row
+			// to retire.
+			if err := m.applyOne(ctx, conn, mg, mg.up, mg.version, mg.version); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// applyOne runs one migration's SQL inside a transaction, recording the
+// resulting version, and marks the row dirty until the transaction commits
+// cleanly. retireVersion is the version whose bookkeeping row should no
+// longer be reported as applied once this step completes: for Up that's the
+// same as resultVersion (a no-op retirement), but for Down it's the
+// migration actually being undone, which must be deleted so Version()
+// reflects the rollback instead of the stale pre-rollback version.
+//
+// The dirty marker is written against retireVersion, not resultVersion: the
+// DDL in sqlText is what tears down retireVersion, so a crash between the
+// commit and the DELETE below must still leave schema_migrations reporting
+// dirty at the version the in-flight DDL actually touched. For Up, and for
+// Goto moving upwards, retireVersion == resultVersion, so both are one row.
+func (m *Migrator) applyOne(ctx context.Context, conn *sql.Conn, mg migration, sqlText string, resultVersion, retireVersion uint64) error {
+	if err := m.ensureBookkeeping(ctx); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, `
+	INSERT INTO schema_migrations (version, dirty) VALUES ($1, true)
+	ON CONFLICT (version) DO UPDATE SET dirty = true`, retireVersion); err != nil {
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, sqlText); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migrations: %03d_%s failed: %w", mg.version, mg.name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if retireVersion != resultVersion {
+		if _, err := conn.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, retireVersion); err != nil {
+			return err
+		}
+	}
+
+	_, err = conn.ExecContext(ctx, `
+	INSERT INTO schema_migrations (version, dirty, applied_at) VALUES ($1, false, now())
+	ON CONFLICT (version) DO UPDATE SET dirty = false, applied_at = now()`, resultVersion)
+	return err
+}
+
+func applicableDown(all []migration, current uint64, steps int) []migration {
+	var candidates []migration
+	for _, mg := range all {
+		if mg.version <= current {
+			candidates = append(candidates, mg)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].version > candidates[j].version })
+	if steps < len(candidates) {
+		candidates = candidates[:steps]
+	}
+	return candidates
+}
+
+// Create scaffolds a new pair of migration files under dir (normally this
+// package's migrations/ directory) named with the next version number.
+func Create(dir, name string) (up, down string, err error) {
+	all, err := loadMigrations()
+	if err != nil {
+		return "", "", err
+	}
+	next := uint64(1)
+	if len(all) > 0 {
+		next = all[len(all)-1].version + 1
+	}
+
+	slug := strings.ReplaceAll(strings.ToLower(name), " ", "_")
+	base := fmt.Sprintf("%03d_%s", next, slug)
+	up = filepath.Join(dir, base+".up.sql")
+	down = filepath.Join(dir, base+".down.sql")
+
+	if err := os.WriteFile(up, []byte("-- write your up migration here\n"), 0o644); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(down, []byte("-- write your down migration here\n"), 0o644); err != nil {
+		return "", "", err
+	}
+	return up, down, nil
+}
@@ -0,0 +1,170 @@
+// Package dbretry provides a retry wrapper for Postgres writes with
+// exponential backoff and jitter, so a transient connection error doesn't
+// have to fail the whole calling HTTP request.
+package dbretry
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	baseDelay = 100 * time.Millisecond
+	capDelay  = 30 * time.Second
+)
+
+// ErrBackingOff is returned by Do when the caller passed NonBlocking and the
+// destination is currently in its backoff window.
+var ErrBackingOff = errors.New("dbretry: destination is backing off")
+
+// Statistics tracks retry outcomes for a logical destination, analogous to
+// Dendrite's statistics.ServerStatistics.
+type Statistics struct {
+	mu           sync.Mutex
+	successCount int64
+	failureCount int64
+	failures     int
+	backoffUntil time.Time
+	lastErr      error
+}
+
+// Snapshot is a point-in-time, lock-free copy of Statistics for reporting.
+type Snapshot struct {
+	SuccessCount int64
+	FailureCount int64
+	Backoff      time.Duration
+	LastError    string
+}
+
+// Snapshot returns the current counters without holding the lock open.
+func (s *Statistics) Snapshot() Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := Snapshot{
+		SuccessCount: s.successCount,
+		FailureCount: s.failureCount,
+	}
+	if until := s.backoffUntil; until.After(time.Now()) {
+		snap.Backoff = time.Until(until)
+	}
+	if s.lastErr != nil {
+		snap.LastError = s.lastErr.Error()
+	}
+	return snap
+}
+
+func (s *Statistics) recordSuccess() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.successCount++
+	s.failures = 0
+	s.backoffUntil = time.Time{}
+}
+
+// recordFailure computes the next backoff window using exponential backoff
+// with jitter: d = min(base*2^(failures-1), cap), then the actual sleep is
+// drawn uniformly from [d/2, 3d/2).
+func (s *Statistics) recordFailure(err error) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failureCount++
+	s.lastErr = err
+	s.failures++
+
+	d := baseDelay * time.Duration(1<<uint(s.failures-1))
+	if d > capDelay || d <= 0 {
+		d = capDelay
+	}
+	jittered := d/2 + time.Duration(rand.Int63n(int64(d)))
+	s.backoffUntil = time.Now().Add(jittered)
+	return jittered
+}
+
+func (s *Statistics) currentBackoff() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if until := s.backoffUntil; until.After(time.Now()) {
+		return time.Until(until)
+	}
+	return 0
+}
+
+// Options configures Do.
+type Options struct {
+	// NonBlocking makes Do return ErrBackingOff immediately instead of
+	// sleeping when the destination is within its backoff window.
+	NonBlocking bool
+}
+
+// Do calls fn, retrying on retryable Postgres/sql errors with exponential
+// backoff and jitter until it succeeds, ctx is cancelled, or a
+// non-retryable error is returned. stats is mutated in place so callers can
+// expose it (e.g. on a /debug/db-stats endpoint).
+func Do(ctx context.Context, stats *Statistics, opts Options, fn func() error) error {
+	for {
+		if wait := stats.currentBackoff(); wait > 0 {
+			if opts.NonBlocking {
+				return ErrBackingOff
+			}
+			if err := sleep(ctx, wait); err != nil {
+				return err
+			}
+		}
+
+		err := fn()
+		if err == nil {
+			stats.recordSuccess()
+			return nil
+		}
+		if !Retryable(err) {
+			return err
+		}
+
+		wait := stats.recordFailure(err)
+		if opts.NonBlocking {
+			return ErrBackingOff
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Retryable reports whether err looks like a transient connection problem:
+// sql.ErrConnDone, or a pq error in class 08 (connection exception) or 57
+// (operator intervention, e.g. admin_shutdown/crash_shutdown).
+func Retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, sql.ErrConnDone) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		class := string(pqErr.Code)[:2]
+		return class == "08" || class == "57"
+	}
+	// net.Conn and driver errors that don't wrap cleanly as pq.Error still
+	// usually surface this way from the driver.
+	msg := err.Error()
+	return strings.Contains(msg, "connection reset") || strings.Contains(msg, "broken pipe")
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OrderColumn is one of the columns QueryRequests can sort and keyset-paginate by.
+type OrderColumn string
+
+const (
+	OrderByID         OrderColumn = "id"
+	OrderByCreatedAt  OrderColumn = "created_at"
+	OrderByMatrixSize OrderColumn = "matrix_size"
+)
+
+// RequestQuery describes a filtered, ordered, paginated read of the
+// requests table.
+type RequestQuery struct {
+	// IP, if set, filters to an exact address (e.g. "10.0.0.5") or, if it
+	// contains a "/", a CIDR range (e.g. "10.0.0.0/24").
+	IP string
+
+	SizeMin *int
+	SizeMax *int
+
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+
+	OrderBy OrderColumn
+	Desc    bool
+
+	// Cursor, if set, resumes from the page after the one that produced it.
+	Cursor string
+	Limit  int
+}
+
+// Page is a single page of results plus what's needed to fetch the next one.
+type Page[T any] struct {
+	Items      []T
+	NextCursor string
+	HasMore    bool
+}
+
+const defaultPageLimit = 50
+
+// validOrderColumns whitelists the columns QueryRequests will splice into
+// the ORDER BY / keyset clauses; anything else falls back to the default.
+// OrderBy comes straight from a query parameter, so it must never reach the
+// query string unvalidated.
+var validOrderColumns = map[OrderColumn]bool{
+	OrderByID:         true,
+	OrderByCreatedAt:  true,
+	OrderByMatrixSize: true,
+}
+
+// cursorKey is the decoded keyset position: the value of the order column,
+// and the id as a tiebreaker.
+type cursorKey struct {
+	orderValue string
+	id         int
+}
+
+func encodeCursor(orderValue string, id int) string {
+	raw := fmt.Sprintf("%s|%d", orderValue, id)
+	return base64.URLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (cursorKey, error) {
+	raw, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil {
+		return cursorKey{}, fmt.Errorf("invalid cursor: %w", err)
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return cursorKey{}, fmt.Errorf("invalid cursor")
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return cursorKey{}, fmt.Errorf("invalid cursor")
+	}
+	return cursorKey{orderValue: parts[0], id: id}, nil
+}
+
+// QueryRequests runs a filtered, ordered, keyset-paginated query against the
+// requests table. Pagination is O(log n) regardless of table size because it
+// uses "WHERE (order_col, id) < (cursor_val, cursor_id)" instead of OFFSET.
+func QueryRequests(ctx context.Context, q RequestQuery) (Page[Requests], error) {
+	orderBy := q.OrderBy
+	if !validOrderColumns[orderBy] {
+		orderBy = OrderByCreatedAt
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultPageLimit
+	}
+
+	var conditions []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if q.IP != "" {
+		if strings.Contains(q.IP, "/") {
+			conditions = append(conditions, fmt.Sprintf("ip::inet <<= %s::cidr", arg(q.IP)))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("ip = %s", arg(q.IP)))
+		}
+	}
+	if q.SizeMin != nil {
+		conditions = append(conditions, fmt.Sprintf("matrix_size >= %s", arg(*q.SizeMin)))
+	}
+	if q.SizeMax != nil {
+		conditions = append(conditions, fmt.Sprintf("matrix_size <= %s", arg(*q.SizeMax)))
+	}
+	if q.CreatedAfter != nil {
+		conditions = append(conditions, fmt.Sprintf("created_at >= %s", arg(*q.CreatedAfter)))
+	}
+	if q.CreatedBefore != nil {
+		conditions = append(conditions, fmt.Sprintf("created_at <= %s", arg(*q.CreatedBefore)))
+	}
+
+	cmp := "<"
+	orderDir := "DESC"
+	if !q.Desc {
+		cmp = ">"
+		orderDir = "ASC"
+	}
+
+	if q.Cursor != "" {
+		key, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return Page[Requests]{}, err
+		}
+		var orderArg interface{} = key.orderValue
+		if orderBy != OrderByCreatedAt {
+			// id and matrix_size are numeric columns; compare numerically.
+			n, err := strconv.Atoi(key.orderValue)
+			if err != nil {
+				return Page[Requests]{}, fmt.Errorf("invalid cursor")
+			}
+			orderArg = n
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s (%s, %s)", orderBy, cmp, arg(orderArg), arg(key.id)))
+	}
+
+	query := fmt.Sprintf("SELECT id, ip, matrix_size, created_at FROM requests")
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT %s", orderBy, orderDir, orderDir, arg(limit+1))
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return Page[Requests]{}, err
+	}
+	defer rows.Close()
+
+	var items []Requests
+	for rows.Next() {
+		var item Requests
+		if err := rows.Scan(&item.ID, &item.IP, &item.MatrixSize, &item.CreatedAt); err != nil {
+			return Page[Requests]{}, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return Page[Requests]{}, err
+	}
+
+	page := Page[Requests]{Items: items}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		page.HasMore = true
+
+		last := page.Items[len(page.Items)-1]
+		var orderValue string
+		switch orderBy {
+		case OrderByID:
+			orderValue = strconv.Itoa(last.ID)
+		case OrderByMatrixSize:
+			orderValue = strconv.Itoa(last.MatrixSize)
+		default:
+			orderValue = last.CreatedAt.Format(time.RFC3339Nano)
+		}
+		page.NextCursor = encodeCursor(orderValue, last.ID)
+	}
+	return page, nil
+}
@@ -0,0 +1,237 @@
+// Package rotate provides a size- and age-based rotating file writer, so a
+// long-running process doesn't grow a single log/trace file unboundedly or
+// lose its history across restarts.
+package rotate
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options configures a RotatingFile.
+type Options struct {
+	// Path is the file that's always written to, e.g. "trace.out". Rotated
+	// copies are named relative to it: trace.20060102-150405.000.out.
+	Path string
+	// MaxSize rotates the file once it exceeds this many bytes. Zero means
+	// no size-based rotation.
+	MaxSize int64
+	// MaxAge rotates the file once it's been open this long. Zero means no
+	// age-based rotation.
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated files to keep, oldest deleted
+	// first. Zero means unlimited.
+	MaxBackups int
+	// MaxAgeRetain deletes rotated files older than this, regardless of
+	// MaxBackups. Zero means unlimited.
+	MaxAgeRetain time.Duration
+}
+
+// RotatingFile is an io.WriteCloser that transparently rotates the
+// underlying *os.File when it grows past MaxSize or MaxAge elapses.
+type RotatingFile struct {
+	opts Options
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// New opens opts.Path, creating it if necessary, ready for writes.
+func New(opts Options) (*RotatingFile, error) {
+	rf := &RotatingFile{opts: opts}
+	if err := rf.openCurrent(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) openCurrent() error {
+	f, err := os.OpenFile(rf.opts.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.file = f
+	rf.size = info.Size()
+	rf.openedAt = time.Now()
+	return nil
+}
+
+// Write implements io.Writer, rotating first if the write would push the
+// file past MaxSize or MaxAge has elapsed.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.shouldRotateLocked(len(p)) {
+		if err := rf.rotateLocked(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *RotatingFile) shouldRotateLocked(nextWrite int) bool {
+	if rf.opts.MaxSize > 0 && rf.size+int64(nextWrite) > rf.opts.MaxSize {
+		return true
+	}
+	if rf.opts.MaxAge > 0 && time.Since(rf.openedAt) > rf.opts.MaxAge {
+		return true
+	}
+	return false
+}
+
+// Rotate forces rotation regardless of size/age thresholds. Safe to call
+// concurrently with Write (e.g. from a SIGHUP handler).
+func (rf *RotatingFile) Rotate() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.rotateLocked()
+}
+
+// rotateLocked closes the current file, renames it to a timestamped backup,
+// gzips that backup asynchronously, opens a fresh file in its place, and
+// prunes old backups. Callers must hold rf.mu.
+func (rf *RotatingFile) rotateLocked() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+
+	backupPath, err := nextBackupName(rf.opts.Path, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(rf.opts.Path, backupPath); err != nil {
+		return err
+	}
+
+	go compressAndPrune(rf.opts, backupPath)
+
+	return rf.openCurrent()
+}
+
+// Close closes the underlying file.
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.file.Close()
+}
+
+// nextBackupName returns base renamed to base.YYYYMMDD-HHMMSS.NNN<ext>,
+// picking the lowest unused NNN (capped at 999) if the timestamp collides
+// with an existing backup, e.g. from two rotations within the same second.
+func nextBackupName(base string, now time.Time) (string, error) {
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	stamp := now.Format("20060102-150405")
+
+	for n := 0; n < 1000; n++ {
+		candidate := fmt.Sprintf("%s.%s.%03d%s", stem, stamp, n, ext)
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("rotate: exhausted backup suffixes for %s at %s", base, stamp)
+}
+
+// compressAndPrune gzips the just-rotated backup and then deletes old
+// backups beyond MaxBackups or MaxAgeRetain.
+func compressAndPrune(opts Options, backupPath string) {
+	gzPath := backupPath + ".gz"
+	if err := gzipFile(backupPath, gzPath); err != nil {
+		fmt.Println("rotate: failed to compress", backupPath, ":", err)
+		return
+	}
+	os.Remove(backupPath)
+
+	if err := pruneBackups(opts); err != nil {
+		fmt.Println("rotate: failed to prune old backups:", err)
+	}
+}
+
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gw := gzip.NewWriter(out)
+	if _, err := io.Copy(gw, in); err != nil {
+		gw.Close()
+		return err
+	}
+	return gw.Close()
+}
+
+// pruneBackups deletes gzipped backups beyond MaxBackups (oldest first) and
+// any backup older than MaxAgeRetain.
+func pruneBackups(opts Options) error {
+	if opts.MaxBackups <= 0 && opts.MaxAgeRetain <= 0 {
+		return nil
+	}
+
+	dir := filepath.Dir(opts.Path)
+	base := filepath.Base(opts.Path)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var backups []string
+	prefix := stem + "."
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(e.Name(), prefix) && strings.HasSuffix(e.Name(), ext+".gz") {
+			backups = append(backups, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(backups) // timestamp+NNN prefix sorts chronologically
+
+	if opts.MaxAgeRetain > 0 {
+		cutoff := time.Now().Add(-opts.MaxAgeRetain)
+		kept := backups[:0]
+		for _, b := range backups {
+			if info, err := os.Stat(b); err == nil && info.ModTime().Before(cutoff) {
+				os.Remove(b)
+				continue
+			}
+			kept = append(kept, b)
+		}
+		backups = kept
+	}
+
+	if opts.MaxBackups > 0 && len(backups) > opts.MaxBackups {
+		for _, b := range backups[:len(backups)-opts.MaxBackups] {
+			os.Remove(b)
+		}
+	}
+	return nil
+}
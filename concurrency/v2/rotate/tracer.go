@@ -0,0 +1,130 @@
+package rotate
+
+import (
+	"fmt"
+	"runtime/trace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Tracer manages the lifecycle of runtime/trace output through a
+// RotatingFile. Unlike a plain log file, runtime/trace's output is one
+// continuous stream with a header written exactly once by trace.Start and
+// no supported way for a consumer (go tool trace) to pick up a new file
+// mid-stream. So Tracer never lets the underlying RotatingFile rotate
+// behind trace's back (that would leave the old file truncated mid-event
+// and the new file missing its header, making both unparseable); instead
+// it tracks size/age itself and rotates by calling trace.Stop(), rotating
+// the file, then trace.Start() again. This briefly pauses tracing, which
+// is the real cost of rotating trace output correctly.
+type Tracer struct {
+	// rotateMu serializes the Stop/rotate-file/Start sequence. It is never
+	// held across a blocking trace.Stop() together with mu, because
+	// trace.Stop() waits for runtime/trace's internal writer goroutine to
+	// make its final Write call, and that call needs mu too.
+	rotateMu sync.Mutex
+	rotating int32 // atomic; set while an auto-triggered rotation is in flight
+
+	mu sync.Mutex // guards rf, size, openedAt
+	rf *RotatingFile
+
+	maxSize  int64
+	maxAge   time.Duration
+	size     int64
+	openedAt time.Time
+}
+
+// NewTracer opens opts.Path and starts runtime/trace writing to it. Size/age
+// rotation thresholds are enforced by Tracer itself, not by the underlying
+// RotatingFile, which is only ever rotated explicitly (see Rotate).
+func NewTracer(opts Options) (*Tracer, error) {
+	rfOpts := opts
+	rfOpts.MaxSize = 0
+	rfOpts.MaxAge = 0
+
+	rf, err := New(rfOpts)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tracer{rf: rf, maxSize: opts.MaxSize, maxAge: opts.MaxAge, openedAt: time.Now()}
+	if err := trace.Start(t); err != nil {
+		rf.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// Write implements io.Writer for runtime/trace. If the write pushes the file
+// past MaxSize or MaxAge has elapsed, it kicks off a rotation on another
+// goroutine rather than rotating inline: Write is called by runtime/trace's
+// own internal writer goroutine, and rotating requires trace.Stop(), which
+// blocks until that same goroutine finishes its current Write and returns.
+// Calling trace.Stop() from inside this call would therefore wait on itself
+// forever.
+func (t *Tracer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	needsRotate := t.shouldRotateLocked(len(p))
+	n, err := t.rf.Write(p)
+	t.size += int64(n)
+	t.mu.Unlock()
+
+	if needsRotate && atomic.CompareAndSwapInt32(&t.rotating, 0, 1) {
+		go func() {
+			defer atomic.StoreInt32(&t.rotating, 0)
+			if err := t.Rotate(); err != nil {
+				fmt.Println("rotate: trace rotation failed:", err)
+			}
+		}()
+	}
+	return n, err
+}
+
+func (t *Tracer) shouldRotateLocked(nextWrite int) bool {
+	if t.maxSize > 0 && t.size+int64(nextWrite) > t.maxSize {
+		return true
+	}
+	if t.maxAge > 0 && time.Since(t.openedAt) > t.maxAge {
+		return true
+	}
+	return false
+}
+
+// Rotate stops the trace, rotates the underlying file, and starts a new
+// trace against it, so each rotated file is a complete, independently
+// parseable trace rather than a fragment of a split stream. It can be
+// called directly from a SIGHUP handler, or indirectly (via Write) from a
+// goroutine spawned for that purpose; either way it must never be called
+// from runtime/trace's own writer goroutine (see Write).
+func (t *Tracer) Rotate() error {
+	t.rotateMu.Lock()
+	defer t.rotateMu.Unlock()
+
+	// trace.Stop() must not be called while holding mu: it blocks until
+	// the writer goroutine's final flush (a Write call, which needs mu)
+	// completes, so holding mu here would deadlock that flush against us.
+	trace.Stop()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.rf.Rotate(); err != nil {
+		return fmt.Errorf("rotate: failed to rotate trace output: %w", err)
+	}
+	t.size = 0
+	t.openedAt = time.Now()
+	if err := trace.Start(t); err != nil {
+		return fmt.Errorf("rotate: failed to restart trace after rotation: %w", err)
+	}
+	return nil
+}
+
+// Stop stops the trace and closes the underlying file.
+func (t *Tracer) Stop() error {
+	trace.Stop()
+
+	t.rotateMu.Lock()
+	defer t.rotateMu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rf.Close()
+}
@@ -0,0 +1,110 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule is a parsed 6-field cron expression (seconds minutes hours
+// day-of-month month day-of-week), matched against the minute/second the
+// scheduler's tick lands on.
+type schedule struct {
+	seconds, minutes, hours, doms, months, dows map[int]bool
+}
+
+var fieldRanges = [6][2]int{
+	{0, 59}, // seconds
+	{0, 59}, // minutes
+	{0, 23}, // hours
+	{1, 31}, // day of month
+	{1, 12}, // month
+	{0, 6},  // day of week, 0 = Sunday
+}
+
+// parseSchedule parses a 6-field cron spec, e.g. "0 5 * * * *" (every hour
+// at minute 5) or "0 0 3 * * *" (daily at 03:00:00).
+func parseSchedule(spec string) (*schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("scheduler: cron spec %q must have 6 fields (sec min hour dom month dow), got %d", spec, len(fields))
+	}
+
+	sets := make([]map[int]bool, 6)
+	for i, field := range fields {
+		set, err := parseField(field, fieldRanges[i][0], fieldRanges[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("scheduler: cron spec %q field %d: %w", spec, i, err)
+		}
+		sets[i] = set
+	}
+
+	return &schedule{
+		seconds: sets[0],
+		minutes: sets[1],
+		hours:   sets[2],
+		doms:    sets[3],
+		months:  sets[4],
+		dows:    sets[5],
+	}, nil
+}
+
+// parseField supports "*", "*/N", "a-b", "a,b,c", and combinations like
+// "a-b/N", which covers everything the jobs in this package need.
+func parseField(field string, min, max int) (map[int]bool, error) {
+	set := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		lo, hi, step := min, max, 1
+
+		rangePart := part
+		if i := strings.Index(part, "/"); i != -1 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("bad step in %q", part)
+			}
+			rangePart = part[:i]
+		}
+
+		switch {
+		case rangePart == "*":
+			lo, hi = min, max
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			lo, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("bad range start in %q", part)
+			}
+			hi, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				return nil, fmt.Errorf("bad range end in %q", part)
+			}
+		default:
+			v, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return nil, fmt.Errorf("bad value %q", rangePart)
+			}
+			lo, hi = v, v
+		}
+
+		for v := lo; v <= hi; v += step {
+			if v < min || v > max {
+				return nil, fmt.Errorf("value %d out of range [%d,%d]", v, min, max)
+			}
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// matches reports whether t falls on this schedule, at second granularity.
+func (s *schedule) matches(t time.Time) bool {
+	return s.seconds[t.Second()] &&
+		s.minutes[t.Minute()] &&
+		s.hours[t.Hour()] &&
+		s.doms[t.Day()] &&
+		s.months[int(t.Month())] &&
+		s.dows[int(t.Weekday())]
+}
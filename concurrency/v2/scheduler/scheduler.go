@@ -0,0 +1,144 @@
+// Package scheduler runs periodic maintenance jobs against Postgres on cron
+// schedules loaded from config, guarding each job with a
+// pg_try_advisory_lock so only one replica executes it at a time, and
+// recording every invocation in a job_runs table.
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+// JobFunc is the work a scheduled job performs. It returns the number of
+// rows affected (for job_runs bookkeeping) or an error.
+type JobFunc func(ctx context.Context, db *sql.DB) (rowsAffected int64, err error)
+
+// Job is one named, scheduled unit of maintenance work.
+type Job struct {
+	Name string
+	Spec string // 6-field cron spec, e.g. "0 5 * * * *"
+	Fn   JobFunc
+}
+
+type entry struct {
+	job      Job
+	schedule *schedule
+	lockKey  int64
+}
+
+// Scheduler ticks once a second, running any job whose schedule matches the
+// current time.
+type Scheduler struct {
+	db      *sql.DB
+	entries []*entry
+	stop    chan struct{}
+}
+
+// New returns a Scheduler backed by db. Jobs are added with Add before
+// calling Start.
+func New(db *sql.DB) *Scheduler {
+	return &Scheduler{db: db, stop: make(chan struct{})}
+}
+
+// Add parses job.Spec and registers it to run on that schedule.
+func (s *Scheduler) Add(job Job) error {
+	sched, err := parseSchedule(job.Spec)
+	if err != nil {
+		return err
+	}
+	s.entries = append(s.entries, &entry{job: job, schedule: sched, lockKey: lockKeyFor(job.Name)})
+	return nil
+}
+
+// lockKeyFor derives a stable advisory lock key from a job name.
+func lockKeyFor(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// Start begins the scheduling loop in a background goroutine.
+func (s *Scheduler) Start() {
+	go s.loop()
+}
+
+// Stop ends the scheduling loop. In-flight jobs are left to finish.
+func (s *Scheduler) Stop() {
+	close(s.stop)
+}
+
+func (s *Scheduler) loop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			for _, e := range s.entries {
+				if e.schedule.matches(now) {
+					go s.runLocked(e)
+				}
+			}
+		}
+	}
+}
+
+// runLocked acquires the job's advisory lock (non-blocking) before running
+// it, so that if several replicas tick at the same moment only one of them
+// actually executes the job.
+func (s *Scheduler) runLocked(e *entry) {
+	conn, err := s.db.Conn(context.Background())
+	if err != nil {
+		fmt.Println("scheduler: failed to acquire connection for", e.job.Name, ":", err)
+		return
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(context.Background(), `SELECT pg_try_advisory_lock($1)`, e.lockKey).Scan(&locked); err != nil {
+		fmt.Println("scheduler: failed to try advisory lock for", e.job.Name, ":", err)
+		return
+	}
+	if !locked {
+		return
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, e.lockKey)
+
+	s.run(e.job)
+}
+
+func (s *Scheduler) run(job Job) {
+	ctx := context.Background()
+	var runID int64
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO job_runs (name, started_at, status) VALUES ($1, now(), 'running') RETURNING id`,
+		job.Name,
+	).Scan(&runID)
+	if err != nil {
+		fmt.Println("scheduler: failed to record job_runs start for", job.Name, ":", err)
+		return
+	}
+
+	rows, runErr := job.Fn(ctx, s.db)
+
+	status := "success"
+	errMsg := ""
+	if runErr != nil {
+		status = "failed"
+		errMsg = runErr.Error()
+		fmt.Println("scheduler: job", job.Name, "failed:", runErr)
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`UPDATE job_runs SET finished_at = now(), status = $1, rows_affected = $2, error = $3 WHERE id = $4`,
+		status, rows, errMsg, runID,
+	)
+	if err != nil {
+		fmt.Println("scheduler: failed to record job_runs finish for", job.Name, ":", err)
+	}
+}